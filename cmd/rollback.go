@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pegnet/pegnetd/config"
+	"github.com/pegnet/pegnetd/node"
+	"github.com/pegnet/pegnetd/node/pegnet"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rollbackCmd.Flags().Uint32("to-height", 0, "roll back all synced state above this directory block height")
+	rollbackCmd.Flags().Bool("dry-run", false, "print the cumulative balance delta per address/ticker without committing")
+	RootCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Roll back synced pegnetd state to an earlier directory block height",
+	Long: "rollback reverses all state pegnetd has recorded above --to-height, using the\n" +
+		"per-height journal DBlockSync maintains while syncing. It refuses to run while\n" +
+		"DBlockSync appears to be actively syncing the same database. With --dry-run, it\n" +
+		"prints the cumulative balance delta per address/ticker the rollback would apply\n" +
+		"without committing anything.",
+	RunE: runRollback,
+}
+
+func runRollback(cmd *cobra.Command, _ []string) error {
+	toHeight, err := cmd.Flags().GetUint32("to-height")
+	if err != nil {
+		return err
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", viper.GetString(config.SqliteDBPath))
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer db.Close()
+
+	pn, err := pegnet.New(db)
+	if err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+	if age, err := pn.SyncHeartbeatAge(); err == nil {
+		if age < node.HeartbeatStaleAfter {
+			return fmt.Errorf("refusing to roll back: pegnetd appears to be actively syncing this database (last heartbeat %s ago); stop it first", age)
+		}
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("checking sync heartbeat: %w", err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	deltas, err := pn.SummarizeRollback(tx, toHeight)
+	if err != nil {
+		return fmt.Errorf("summarizing rollback: %w", err)
+	}
+	for _, delta := range deltas {
+		fmt.Printf("address_id=%d ticker=%s delta=%+d\n", delta.AddressID, delta.Ticker, delta.Delta)
+	}
+	// The summary above is read-only; nothing to commit. The deferred
+	// Rollback releases it in every case, including this early return.
+	if dryRun {
+		return nil
+	}
+
+	// The write below reuses this same tx rather than opening a second one:
+	// sqlite only allows one writer at a time, and a second BeginTx here
+	// would either block forever behind this tx's still-held connection or
+	// fail outright with "database is locked".
+	if err := pn.RollbackToHeight(tx, toHeight); err != nil {
+		return fmt.Errorf("rolling back to height %d: %w", toHeight, err)
+	}
+	if err := pn.InsertSyncedHeight(tx, toHeight); err != nil {
+		return fmt.Errorf("updating synced height: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing rollback: %w", err)
+	}
+	fmt.Printf("rolled back to height %d\n", toHeight)
+	return nil
+}
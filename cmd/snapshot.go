@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pegnet/pegnetd/config"
+	"github.com/pegnet/pegnetd/node"
+	"github.com/pegnet/pegnetd/node/pegnet"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	snapshotImportCmd.Flags().Bool("trust", false, "import the snapshot without a locally recorded manifest entry to verify it against")
+	snapshotCmd.AddCommand(snapshotVerifyCmd, snapshotImportCmd)
+	RootCmd.AddCommand(snapshotCmd)
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Inspect and bootstrap from balance-tree snapshots",
+}
+
+var snapshotVerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Recompute a snapshot file's hash and compare it to the locally recorded manifest",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSnapshotVerify,
+}
+
+var snapshotImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Seed a fresh database from a snapshot file so a new node can skip straight to its height",
+	Long: "import seeds an already-initialized, empty database with the balances, rate tip\n" +
+		"and holding set recorded in a snapshot file, so a new node can start syncing from\n" +
+		"that height instead of replaying the chain from genesis. --trust is required\n" +
+		"because a freshly bootstrapped node has no prior manifest of its own to verify the\n" +
+		"snapshot against; run `snapshot verify` first against a manifest from a node you\n" +
+		"already trust if one is available.",
+	Args: cobra.ExactArgs(1),
+	RunE: runSnapshotImport,
+}
+
+func runSnapshotVerify(cmd *cobra.Command, args []string) error {
+	raw, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("reading snapshot file: %w", err)
+	}
+
+	var snap pegnet.Snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return fmt.Errorf("parsing snapshot file: %w", err)
+	}
+
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])
+
+	dir := viper.GetString(config.SnapshotDir)
+	entry, err := node.ReadManifestEntry(dir, snap.Height)
+	if err != nil {
+		return fmt.Errorf("reading manifest entry for height %d: %w", snap.Height, err)
+	}
+
+	if entry.Hash != hash {
+		return fmt.Errorf("hash mismatch for height %d: manifest has %s, file hashes to %s", snap.Height, entry.Hash, hash)
+	}
+	fmt.Printf("OK: snapshot for height %d matches recorded manifest (%s)\n", snap.Height, hash)
+	return nil
+}
+
+func runSnapshotImport(cmd *cobra.Command, args []string) error {
+	trust, err := cmd.Flags().GetBool("trust")
+	if err != nil {
+		return err
+	}
+	if !trust {
+		return fmt.Errorf("refusing to import a snapshot without --trust")
+	}
+
+	raw, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("reading snapshot file: %w", err)
+	}
+
+	var snap pegnet.Snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return fmt.Errorf("parsing snapshot file: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", viper.GetString(config.SqliteDBPath))
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer db.Close()
+
+	pn, err := pegnet.New(db)
+	if err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := pn.ApplySnapshot(tx, &snap); err != nil {
+		return fmt.Errorf("applying snapshot: %w", err)
+	}
+	if err := pn.InsertSyncedHeight(tx, snap.Height); err != nil {
+		return fmt.Errorf("recording synced height: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing import: %w", err)
+	}
+	fmt.Printf("imported snapshot, ready to sync from height %d\n", snap.Height)
+	return nil
+}
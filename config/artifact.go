@@ -0,0 +1,5 @@
+package config
+
+// DebugArtifactDir is the directory ArtifactWriter dumps per-height debug
+// artifacts under. The feature is disabled when this is unset.
+const DebugArtifactDir = "debugartifactdir"
@@ -0,0 +1,10 @@
+package config
+
+// FactomRPCMaxAttempts, FactomRPCInitialBackoff and FactomRPCMaxBackoff tune
+// FactomRPC's per-method retry behavior. Each falls back to node's own
+// DefaultFactomRPC* constant when unset or zero.
+const (
+	FactomRPCMaxAttempts    = "factomrpcmaxattempts"
+	FactomRPCInitialBackoff = "factomrpcinitialbackoff"
+	FactomRPCMaxBackoff     = "factomrpcmaxbackoff"
+)
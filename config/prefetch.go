@@ -0,0 +1,10 @@
+package config
+
+// PrefetchDepth and PrefetchWorkers tune the node.Prefetcher started by
+// DBlockSync: PrefetchDepth bounds how many hydrated blocks it buffers ahead
+// of the apply loop, and PrefetchWorkers is the size of its fetch worker
+// pool. Both fall back to node's own defaults when unset or zero.
+const (
+	PrefetchDepth   = "prefetchdepth"
+	PrefetchWorkers = "prefetchworkers"
+)
@@ -0,0 +1,6 @@
+package config
+
+// ReorgLookback is how many already-synced heights checkForReorg re-checks
+// against factomd on every sync tick before it starts walking further back.
+// Falls back to node.DefaultReorgLookback when unset or zero.
+const ReorgLookback = "reorglookback"
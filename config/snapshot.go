@@ -0,0 +1,13 @@
+package config
+
+// SnapshotDir is where maybeSnapshot writes content-addressed balance-tree
+// snapshots and their manifest. Automatic snapshotting is disabled when
+// this is unset.
+//
+// SnapshotInterval is how many synced heights pass between automatic
+// snapshots; it falls back to node.DefaultSnapshotInterval when unset or
+// zero.
+const (
+	SnapshotDir      = "snapshotdir"
+	SnapshotInterval = "snapshotinterval"
+)
@@ -0,0 +1,195 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/pegnet/pegnet/modules/grader"
+	"github.com/pegnet/pegnetd/config"
+	"github.com/pegnet/pegnetd/fat/fat2"
+	log "github.com/sirupsen/logrus"
+)
+
+// ArtifactWriter dumps every stage of block processing to disk as JSON, one
+// directory per height, so a height can be replayed or inspected offline
+// without talking to factomd. It is only active when config.DebugArtifactDir
+// is set; artifactWriter returns nil otherwise, and every hook call site
+// no-ops on a nil writer.
+type ArtifactWriter struct {
+	dir string
+}
+
+// artifactWriter returns an ArtifactWriter rooted at config.DebugArtifactDir,
+// or nil if that config key is unset, meaning the feature is disabled.
+func artifactWriter(d *Pegnetd) *ArtifactWriter {
+	dir := d.Config.GetString(config.DebugArtifactDir)
+	if dir == "" {
+		return nil
+	}
+	return &ArtifactWriter{dir: dir}
+}
+
+func (w *ArtifactWriter) heightDir(height uint32) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%d", height))
+}
+
+func (w *ArtifactWriter) write(height uint32, name string, v interface{}) {
+	dir := w.heightDir(height)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height}).Warnf("debug artifact: failed to create dir")
+		return
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height, "artifact": name}).Warnf("debug artifact: failed to marshal")
+		return
+	}
+
+	path := filepath.Join(dir, name+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height, "artifact": name}).Warnf("debug artifact: failed to write")
+	}
+}
+
+// WriteDBlock dumps the raw DBlock for height.
+func (w *ArtifactWriter) WriteDBlock(height uint32, dblock *factom.DBlock) {
+	if w == nil {
+		return
+	}
+	w.write(height, "dblock", dblock)
+}
+
+// WriteOPREntries dumps the raw OPR EBlock entries for height.
+func (w *ArtifactWriter) WriteOPREntries(height uint32, eblock *factom.EBlock) {
+	if w == nil || eblock == nil {
+		return
+	}
+	w.write(height, "opr-entries", eblock.Entries)
+}
+
+// WriteTransactionEntries dumps the raw transaction EBlock entries for height.
+func (w *ArtifactWriter) WriteTransactionEntries(height uint32, eblock *factom.EBlock) {
+	if w == nil || eblock == nil {
+		return
+	}
+	w.write(height, "transaction-entries", eblock.Entries)
+}
+
+type gradedWinnerArtifact struct {
+	EntryHash string `json:"entryhash"`
+	Address   string `json:"address"`
+	Payout    int64  `json:"payout"`
+}
+
+// WriteGradedBlock dumps the graded block's winners and their payouts.
+func (w *ArtifactWriter) WriteGradedBlock(height uint32, graded grader.GradedBlock) {
+	if w == nil || graded == nil {
+		return
+	}
+	winners := graded.Winners()
+	out := make([]gradedWinnerArtifact, len(winners))
+	for i := range winners {
+		out[i] = gradedWinnerArtifact{
+			EntryHash: fmt.Sprintf("%x", winners[i].EntryHash),
+			Address:   winners[i].OPR.GetAddress(),
+			Payout:    winners[i].Payout(),
+		}
+	}
+	w.write(height, "graded-winners", out)
+}
+
+// WriteRates dumps the rates inserted for height, in whatever ordered form
+// InsertRate received them.
+func (w *ArtifactWriter) WriteRates(height uint32, rates interface{}) {
+	if w == nil {
+		return
+	}
+	w.write(height, "rates", rates)
+}
+
+// TransactionBatchOutcome describes what happened to a fat2.TransactionBatch
+// seen during block processing: held, applied, or rejected with a reason.
+type TransactionBatchOutcome struct {
+	EntryHash string `json:"entryhash"`
+	Outcome   string `json:"outcome"` // "held", "applied", or "rejected"
+	Reason    string `json:"reason,omitempty"`
+}
+
+// WriteTransactionBatch records the outcome of a single transaction batch.
+// Multiple calls for the same height accumulate into one artifact file.
+func (w *ArtifactWriter) WriteTransactionBatch(height uint32, hash factom.Bytes32, outcome, reason string) {
+	if w == nil {
+		return
+	}
+	w.appendJSONL(height, "transaction-batches", TransactionBatchOutcome{
+		EntryHash: hash.String(),
+		Outcome:   outcome,
+		Reason:    reason,
+	})
+}
+
+// BurnArtifact records a single FCT --> pFCT burn credited during ApplyFactoidBlock.
+type BurnArtifact struct {
+	Address string `json:"address"`
+	Amount  uint64 `json:"amount"`
+}
+
+// WriteBurns dumps the list of FCT burns credited for height.
+func (w *ArtifactWriter) WriteBurns(height uint32, burns []BurnArtifact) {
+	if w == nil {
+		return
+	}
+	w.write(height, "burns", burns)
+}
+
+// BalanceDeltaArtifact records a single balance mutation applied for height.
+type BalanceDeltaArtifact struct {
+	Address string       `json:"address"`
+	Ticker  fat2.PTicker `json:"ticker"`
+	Delta   int64        `json:"delta"`
+}
+
+// WriteBalanceDelta records a single balance mutation applied for height.
+// Multiple calls for the same height accumulate into one artifact file.
+func (w *ArtifactWriter) WriteBalanceDelta(height uint32, address fmt.Stringer, ticker fat2.PTicker, delta int64) {
+	if w == nil {
+		return
+	}
+	w.appendJSONL(height, "balance-deltas", BalanceDeltaArtifact{
+		Address: address.String(),
+		Ticker:  ticker,
+		Delta:   delta,
+	})
+}
+
+// appendJSONL appends one JSON object per line to name.jsonl under height's
+// directory, used for artifacts that accumulate across multiple calls within
+// a single height (e.g. one transaction batch at a time).
+func (w *ArtifactWriter) appendJSONL(height uint32, name string, v interface{}) {
+	dir := w.heightDir(height)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height}).Warnf("debug artifact: failed to create dir")
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height, "artifact": name}).Warnf("debug artifact: failed to marshal")
+		return
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height, "artifact": name}).Warnf("debug artifact: failed to open")
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.WithError(err).WithFields(log.Fields{"height": height, "artifact": name}).Warnf("debug artifact: failed to append")
+	}
+}
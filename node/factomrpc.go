@@ -0,0 +1,291 @@
+package node
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/pegnet/pegnetd/config"
+	"github.com/spf13/viper"
+)
+
+// Defaults used when the corresponding config key is unset or zero.
+const (
+	DefaultFactomRPCMaxAttempts    = 5
+	DefaultFactomRPCInitialBackoff = 250 * time.Millisecond
+	DefaultFactomRPCMaxBackoff     = 10 * time.Second
+)
+
+// FactomRPC wraps a factom.Client so every call goes through bounded,
+// exponential-backoff-with-jitter retries instead of surfacing the first
+// error to the outer DBlockSync retry loop, which would otherwise redo all
+// completed work for the height and sleep a flat retry period. Errors are
+// classified as transient (worth retrying: connection refused, 5xx, EOF) or
+// permanent (not found, malformed) before a retry is attempted.
+type FactomRPC struct {
+	Client *factom.Client
+
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	metrics *rpcMetrics
+}
+
+// NewFactomRPC builds a FactomRPC around client, reading retry tuning from
+// conf (config.FactomRPCMaxAttempts, config.FactomRPCInitialBackoff,
+// config.FactomRPCMaxBackoff), falling back to defaults for unset keys.
+func NewFactomRPC(client *factom.Client, conf *viper.Viper) *FactomRPC {
+	r := &FactomRPC{
+		Client:         client,
+		MaxAttempts:    conf.GetInt(config.FactomRPCMaxAttempts),
+		InitialBackoff: conf.GetDuration(config.FactomRPCInitialBackoff),
+		MaxBackoff:     conf.GetDuration(config.FactomRPCMaxBackoff),
+		metrics:        globalRPCMetrics,
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = DefaultFactomRPCMaxAttempts
+	}
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = DefaultFactomRPCInitialBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultFactomRPCMaxBackoff
+	}
+	return r
+}
+
+// GetDBlock fetches dblock, retrying transient failures.
+func (r *FactomRPC) GetDBlock(ctx context.Context, dblock *factom.DBlock) error {
+	return r.do(ctx, "dblock.Get", func() error { return dblock.Get(r.Client) })
+}
+
+// GetEBlock fetches eblock, retrying transient failures.
+func (r *FactomRPC) GetEBlock(ctx context.Context, eblock *factom.EBlock) error {
+	return r.do(ctx, "eblock.Get", func() error { return eblock.Get(r.Client) })
+}
+
+// GetEntry fetches entry, retrying transient failures.
+func (r *FactomRPC) GetEntry(ctx context.Context, entry *factom.Entry) error {
+	return r.do(ctx, "entry.Get", func() error { return entry.Get(r.Client) })
+}
+
+// GetFBlock fetches fblock, retrying transient failures.
+func (r *FactomRPC) GetFBlock(ctx context.Context, fblock *factom.FBlock) error {
+	return r.do(ctx, "fblock.Get", func() error { return fblock.Get(r.Client) })
+}
+
+// GetFactoidTransaction fetches txn, retrying transient failures.
+func (r *FactomRPC) GetFactoidTransaction(ctx context.Context, txn *factom.FactoidTransaction) error {
+	return r.do(ctx, "factoidtransaction.Get", func() error { return txn.Get(r.Client) })
+}
+
+// do runs call up to MaxAttempts times, sleeping a jittered exponential
+// backoff between attempts, and bails immediately on a permanent error or
+// context cancellation.
+func (r *FactomRPC) do(ctx context.Context, method string, call func() error) error {
+	backoff := r.InitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= r.MaxAttempts; attempt++ {
+		if isDone(ctx) {
+			return context.Canceled
+		}
+
+		start := time.Now()
+		err := callWithContext(ctx, call)
+		r.metrics.observe(method, err, time.Since(start))
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if err == context.Canceled {
+			return err
+		}
+
+		if !isTransientRPCErr(err) || attempt == r.MaxAttempts {
+			break
+		}
+
+		sleep := jitter(backoff, r.MaxBackoff)
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return context.Canceled
+		}
+		backoff *= 2
+		if backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+	return lastErr
+}
+
+// callWithContext runs call on its own goroutine and returns as soon as
+// either it completes or ctx is cancelled, whichever happens first.
+//
+// factom.Client's Get methods take no context and are not themselves
+// cancelable, so a call abandoned here may keep running in the background
+// against the underlying HTTP connection until it eventually completes or
+// the standard library's own transport timeouts kick in. That's still
+// enough to unblock a shutdown: DBlockSync stops waiting on this call and
+// rolls back rather than hanging indefinitely on a wedged factomd.
+func callWithContext(ctx context.Context, call func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return context.Canceled
+	}
+}
+
+// jitter returns a random duration in [0, min(backoff, max)), i.e. "full
+// jitter", which spreads out retries from many clients hitting the same
+// rebooted factomd instance at once.
+func jitter(backoff, max time.Duration) time.Duration {
+	if backoff > max {
+		backoff = max
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// isTransientRPCErr reports whether err looks like a temporary factomd
+// hiccup (connection refused, timeout, EOF, 5xx) as opposed to a permanent
+// one (not found, malformed request) that retrying cannot fix.
+func isTransientRPCErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	var netErr net.Error
+	if ok := asNetError(err, &netErr); ok {
+		return netErr.Timeout() || isTemporary(netErr)
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "eof"),
+		strings.Contains(msg, "timeout"),
+		strings.Contains(msg, "temporarily unavailable"),
+		strings.Contains(msg, "503"),
+		strings.Contains(msg, "502"),
+		strings.Contains(msg, "500"):
+		return true
+	}
+	return false
+}
+
+// asNetError walks err's Unwrap chain, much like errors.As, and stores the
+// first net.Error it finds in target.
+func asNetError(err error, target *net.Error) bool {
+	for err != nil {
+		if ne, ok := err.(net.Error); ok {
+			*target = ne
+			return true
+		}
+		unwrap, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = unwrap.Unwrap()
+	}
+	return false
+}
+
+// isTemporary reports err.Temporary() if the net.Error implements the
+// (deprecated but still widely implemented) Temporary() method.
+func isTemporary(err net.Error) bool {
+	type temporary interface {
+		Temporary() bool
+	}
+	if t, ok := err.(temporary); ok {
+		return t.Temporary()
+	}
+	return false
+}
+
+// rpcMethodStats accumulates attempt/failure counts and total latency for a
+// single RPC method, suitable for exposing as Prometheus-style counters and a
+// crude average-latency gauge.
+type rpcMethodStats struct {
+	attempts  uint64
+	failures  uint64
+	latencyNs uint64 // sum, for computing an average
+}
+
+// rpcMetrics tracks per-method rpcMethodStats across every FactomRPC sharing
+// it. FactomRPC instances share the package-level globalRPCMetrics by
+// default, so metrics accumulate process-wide regardless of how many
+// FactomRPC wrappers get constructed.
+type rpcMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*rpcMethodStats
+}
+
+func newRPCMetrics() *rpcMetrics {
+	return &rpcMetrics{stats: make(map[string]*rpcMethodStats)}
+}
+
+var globalRPCMetrics = newRPCMetrics()
+
+func (m *rpcMetrics) observe(method string, err error, took time.Duration) {
+	m.mu.Lock()
+	s, ok := m.stats[method]
+	if !ok {
+		s = &rpcMethodStats{}
+		m.stats[method] = s
+	}
+	m.mu.Unlock()
+
+	atomic.AddUint64(&s.attempts, 1)
+	atomic.AddUint64(&s.latencyNs, uint64(took.Nanoseconds()))
+	if err != nil {
+		atomic.AddUint64(&s.failures, 1)
+	}
+}
+
+// RPCMethodMetrics is a point-in-time snapshot of a single method's stats.
+type RPCMethodMetrics struct {
+	Method     string
+	Attempts   uint64
+	Failures   uint64
+	AvgLatency time.Duration
+}
+
+// FactomRPCMetrics returns a snapshot of every method's accumulated metrics,
+// for operators to expose however they expose the rest of pegnetd's metrics.
+func FactomRPCMetrics() []RPCMethodMetrics {
+	globalRPCMetrics.mu.Lock()
+	defer globalRPCMetrics.mu.Unlock()
+
+	out := make([]RPCMethodMetrics, 0, len(globalRPCMetrics.stats))
+	for method, s := range globalRPCMetrics.stats {
+		attempts := atomic.LoadUint64(&s.attempts)
+		var avg time.Duration
+		if attempts > 0 {
+			avg = time.Duration(atomic.LoadUint64(&s.latencyNs) / attempts)
+		}
+		out = append(out, RPCMethodMetrics{
+			Method:     method,
+			Attempts:   attempts,
+			Failures:   atomic.LoadUint64(&s.failures),
+			AvgLatency: avg,
+		})
+	}
+	return out
+}
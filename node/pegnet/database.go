@@ -0,0 +1,31 @@
+package pegnet
+
+import "database/sql"
+
+// New opens a Pegnet handle around db and ensures every table this package
+// owns exists, so callers never have to remember to migrate separately
+// before using it.
+func New(db *sql.DB) (*Pegnet, error) {
+	p := &Pegnet{DB: db}
+	if err := p.EnsureSchema(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// EnsureSchema creates every table this package owns that does not already
+// exist. It is idempotent and safe to call on every startup, including
+// against a database that was already migrated by an earlier version of
+// pegnetd.
+//
+// This only covers tables owned by this package (the journal tables and the
+// sync heartbeat); the core schema (pn_address, pn_balance, pn_synced, etc.)
+// is migrated elsewhere.
+func (p *Pegnet) EnsureSchema() error {
+	for _, schema := range []string{journalSchema, heartbeatSchema} {
+		if _, err := p.DB.Exec(schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
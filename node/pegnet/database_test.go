@@ -0,0 +1,108 @@
+package pegnet
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pegnet/pegnetd/fat/fat2"
+)
+
+// openTestDB opens a fresh, unmigrated in-memory database, mirroring how
+// DBlockSync and the rollback/snapshot CLIs each open their own *sql.DB
+// before a Pegnet ever touches it.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestNewMigratesJournalAndHeartbeatTables exercises the exact regression
+// that let SyncBlock fail on every height against a fresh database: the
+// journal and heartbeat tables were never created anywhere. It drives the
+// same sequence of journal writes applyPrefetchedBlock makes while syncing a
+// height, end to end against a real, freshly migrated database.
+func TestNewMigratesJournalAndHeartbeatTables(t *testing.T) {
+	db := openTestDB(t)
+
+	p, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	defer tx.Rollback()
+
+	const height = 42
+	var keyMR factom.Bytes32
+	keyMR[0] = 0xAB
+	var entryHash factom.Bytes32
+	entryHash[0] = 0xCD
+
+	if err := p.TouchHeartbeat(tx); err != nil {
+		t.Fatalf("TouchHeartbeat: %v", err)
+	}
+	if err := p.InsertDBlockKeyMR(tx, height, keyMR); err != nil {
+		t.Fatalf("InsertDBlockKeyMR: %v", err)
+	}
+	if err := p.JournalGradeBlock(tx, height); err != nil {
+		t.Fatalf("JournalGradeBlock: %v", err)
+	}
+	if err := p.JournalRate(tx, height); err != nil {
+		t.Fatalf("JournalRate: %v", err)
+	}
+	if err := p.JournalHolding(tx, height, entryHash); err != nil {
+		t.Fatalf("JournalHolding: %v", err)
+	}
+	if err := p.JournalBalanceDelta(tx, height, 1, fat2.PTickerPEG, 100); err != nil {
+		t.Fatalf("JournalBalanceDelta: %v", err)
+	}
+	if err := p.JournalTxRelation(tx, height, entryHash); err != nil {
+		t.Fatalf("JournalTxRelation: %v", err)
+	}
+	if err := p.JournalBurn(tx, height, 1, 50); err != nil {
+		t.Fatalf("JournalBurn: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	age, err := p.SyncHeartbeatAge()
+	if err != nil {
+		t.Fatalf("SyncHeartbeatAge: %v", err)
+	}
+	if age < 0 {
+		t.Fatalf("heartbeat age is negative: %v", age)
+	}
+
+	stored, err := p.SelectDBlockKeyMR(height)
+	if err != nil {
+		t.Fatalf("SelectDBlockKeyMR: %v", err)
+	}
+	if stored == nil || *stored != keyMR {
+		t.Fatalf("SelectDBlockKeyMR returned %v, want %v", stored, keyMR)
+	}
+}
+
+// TestNewIsIdempotent confirms EnsureSchema can run again against an
+// already-migrated database, as happens on every pegnetd restart.
+func TestNewIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+
+	if _, err := New(db); err != nil {
+		t.Fatalf("first New: %v", err)
+	}
+	if _, err := New(db); err != nil {
+		t.Fatalf("second New: %v", err)
+	}
+}
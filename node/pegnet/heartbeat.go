@@ -0,0 +1,34 @@
+package pegnet
+
+import (
+	"database/sql"
+	"time"
+)
+
+const heartbeatSchema = `
+CREATE TABLE IF NOT EXISTS pn_sync_heartbeat (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	updated_at INTEGER NOT NULL
+);
+`
+
+// TouchHeartbeat records that DBlockSync is alive and actively working this
+// database, so a concurrently-invoked `pegnetd rollback` can tell the
+// database is still being synced and refuse to run.
+func (p *Pegnet) TouchHeartbeat(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO pn_sync_heartbeat (id, updated_at) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET updated_at = excluded.updated_at`, time.Now().Unix())
+	return err
+}
+
+// SyncHeartbeatAge returns how long it has been since DBlockSync last
+// touched its heartbeat. It returns sql.ErrNoRows if DBlockSync has never run
+// against this database.
+func (p *Pegnet) SyncHeartbeatAge() (time.Duration, error) {
+	var updatedAt int64
+	err := p.DB.QueryRow(`SELECT updated_at FROM pn_sync_heartbeat WHERE id = 1`).Scan(&updatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(time.Unix(updatedAt, 0)), nil
+}
@@ -0,0 +1,132 @@
+package pegnet
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/pegnet/pegnetd/fat/fat2"
+)
+
+// The journal tables record every state mutation SyncBlock makes, keyed by
+// the height it happened at, so a height can be undone without replaying the
+// chain. Entries are always written in the same sql.Tx as the mutation they
+// describe, so rolling back that tx rolls back its journal rows too.
+const journalSchema = `
+CREATE TABLE IF NOT EXISTS pn_journal_balance (
+	id INTEGER PRIMARY KEY,
+	height INTEGER NOT NULL,
+	address_id INTEGER NOT NULL,
+	ticker INTEGER NOT NULL,
+	delta INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_pn_journal_balance_height ON pn_journal_balance(height);
+
+CREATE TABLE IF NOT EXISTS pn_journal_grade_block (
+	height INTEGER PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS pn_journal_rate (
+	height INTEGER PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS pn_journal_tx_relation (
+	id INTEGER PRIMARY KEY,
+	height INTEGER NOT NULL,
+	entry_hash BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_pn_journal_tx_relation_height ON pn_journal_tx_relation(height);
+
+CREATE TABLE IF NOT EXISTS pn_journal_holding (
+	id INTEGER PRIMARY KEY,
+	height INTEGER NOT NULL,
+	entry_hash BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_pn_journal_holding_height ON pn_journal_holding(height);
+
+CREATE TABLE IF NOT EXISTS pn_journal_burn (
+	id INTEGER PRIMARY KEY,
+	height INTEGER NOT NULL,
+	address_id INTEGER NOT NULL,
+	amount INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_pn_journal_burn_height ON pn_journal_burn(height);
+
+CREATE TABLE IF NOT EXISTS pn_dblock_keymr (
+	height INTEGER PRIMARY KEY,
+	keymr BLOB NOT NULL
+);
+`
+
+// JournalBalanceDelta records that delta was applied to addressID's balance
+// of ticker at height, so RollbackToHeight can later apply the inverse.
+// Callers should invoke this immediately after the AddToBalance/SubFromBalance
+// call it corresponds to, in the same sqlTx.
+func (p *Pegnet) JournalBalanceDelta(tx *sql.Tx, height uint32, addressID int64, ticker fat2.PTicker, delta int64) error {
+	_, err := tx.Exec(`INSERT INTO pn_journal_balance (height, address_id, ticker, delta) VALUES (?, ?, ?, ?)`,
+		height, addressID, ticker, delta)
+	if err != nil {
+		return fmt.Errorf("journal balance delta: %w", err)
+	}
+	return nil
+}
+
+// JournalGradeBlock marks that a grade block was inserted at height.
+func (p *Pegnet) JournalGradeBlock(tx *sql.Tx, height uint32) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO pn_journal_grade_block (height) VALUES (?)`, height)
+	return err
+}
+
+// JournalRate marks that a rate was inserted at height.
+func (p *Pegnet) JournalRate(tx *sql.Tx, height uint32) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO pn_journal_rate (height) VALUES (?)`, height)
+	return err
+}
+
+// JournalTxRelation records that a transaction relation for entryHash was
+// inserted at height.
+func (p *Pegnet) JournalTxRelation(tx *sql.Tx, height uint32, entryHash factom.Bytes32) error {
+	_, err := tx.Exec(`INSERT INTO pn_journal_tx_relation (height, entry_hash) VALUES (?, ?)`,
+		height, entryHash[:])
+	return err
+}
+
+// JournalHolding records that a transaction batch was promoted into holding
+// at height.
+func (p *Pegnet) JournalHolding(tx *sql.Tx, height uint32, entryHash factom.Bytes32) error {
+	_, err := tx.Exec(`INSERT INTO pn_journal_holding (height, entry_hash) VALUES (?, ?)`,
+		height, entryHash[:])
+	return err
+}
+
+// JournalBurn records that an FCT burn credited addressID with amount at
+// height.
+func (p *Pegnet) JournalBurn(tx *sql.Tx, height uint32, addressID int64, amount uint64) error {
+	_, err := tx.Exec(`INSERT INTO pn_journal_burn (height, address_id, amount) VALUES (?, ?, ?)`,
+		height, addressID, amount)
+	return err
+}
+
+// InsertDBlockKeyMR records the KeyMR we synced for height, so a later sync
+// tick can detect whether factomd has since reorganized away from it.
+func (p *Pegnet) InsertDBlockKeyMR(tx *sql.Tx, height uint32, keyMR factom.Bytes32) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO pn_dblock_keymr (height, keymr) VALUES (?, ?)`,
+		height, keyMR[:])
+	return err
+}
+
+// SelectDBlockKeyMR returns the KeyMR we recorded for height, or nil if we
+// have no record of that height.
+func (p *Pegnet) SelectDBlockKeyMR(height uint32) (*factom.Bytes32, error) {
+	var raw []byte
+	err := p.DB.QueryRow(`SELECT keymr FROM pn_dblock_keymr WHERE height = ?`, height).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keyMR factom.Bytes32
+	copy(keyMR[:], raw)
+	return &keyMR, nil
+}
@@ -0,0 +1,176 @@
+package pegnet
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pegnet/pegnetd/fat/fat2"
+)
+
+// RollbackDelta is the net balance change RollbackToHeight would apply to a
+// single address/ticker pair if run right now.
+type RollbackDelta struct {
+	AddressID int64
+	Ticker    fat2.PTicker
+	Delta     int64 // positive: rollback would credit this address; negative: debit
+}
+
+// SummarizeRollback computes, without mutating anything, the net balance
+// delta RollbackToHeight(tx, height) would apply per address/ticker. It
+// negates the sum of journaled deltas above height, mirroring what
+// RollbackToHeight actually does when it reverses them.
+func (p *Pegnet) SummarizeRollback(tx *sql.Tx, height uint32) ([]RollbackDelta, error) {
+	rows, err := tx.Query(`
+		SELECT address_id, ticker, -SUM(delta)
+		FROM pn_journal_balance
+		WHERE height > ?
+		GROUP BY address_id, ticker`, height)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deltas []RollbackDelta
+	for rows.Next() {
+		var d RollbackDelta
+		if err := rows.Scan(&d.AddressID, &d.Ticker, &d.Delta); err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas, rows.Err()
+}
+
+// RollbackToHeight reverses every mutation journaled above height (exclusive),
+// using the per-height journal tables populated during SyncBlock:
+//   - balance deltas (PEG rewards, FCT burns, transfers, conversions) are
+//     undone in reverse insertion order
+//   - grade blocks, rates, promoted-holding entries and tx relations recorded
+//     above height are deleted outright, since they have no prior state to
+//     restore
+//
+// The caller is responsible for running this in the same sql.Tx that also
+// resets the synced height, and for committing or rolling back that tx.
+func (p *Pegnet) RollbackToHeight(tx *sql.Tx, height uint32) error {
+	if err := reverseBalances(tx, height); err != nil {
+		return fmt.Errorf("reverse balances: %w", err)
+	}
+	if err := deleteAboveHeight(tx, "pn_grade_block", height); err != nil {
+		return fmt.Errorf("remove grade blocks: %w", err)
+	}
+	if err := deleteAboveHeight(tx, "pn_rate", height); err != nil {
+		return fmt.Errorf("remove rates: %w", err)
+	}
+	if err := deleteTxRelationsAboveHeight(tx, height); err != nil {
+		return fmt.Errorf("remove tx relations: %w", err)
+	}
+	if err := deleteHoldingAboveHeight(tx, height); err != nil {
+		return fmt.Errorf("remove holding entries: %w", err)
+	}
+	if err := purgeJournalAboveHeight(tx, height); err != nil {
+		return fmt.Errorf("purge journal: %w", err)
+	}
+	return nil
+}
+
+// reverseBalances applies the inverse of every journaled balance delta above
+// height, in descending insertion order so chains of dependent mutations
+// (e.g. a conversion's debit followed by its credit) unwind cleanly.
+func reverseBalances(tx *sql.Tx, height uint32) error {
+	rows, err := tx.Query(`SELECT address_id, ticker, delta FROM pn_journal_balance WHERE height > ? ORDER BY id DESC`, height)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type delta struct {
+		addressID int64
+		ticker    uint64
+		amount    int64
+	}
+	var deltas []delta
+	for rows.Next() {
+		var d delta
+		if err := rows.Scan(&d.addressID, &d.ticker, &d.amount); err != nil {
+			return err
+		}
+		deltas = append(deltas, d)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range deltas {
+		if _, err := tx.Exec(`UPDATE pn_balance SET balance = balance - ? WHERE address_id = ? AND ticker = ?`,
+			d.amount, d.addressID, d.ticker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteAboveHeight(tx *sql.Tx, table string, height uint32) error {
+	_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE height > ?`, table), height)
+	return err
+}
+
+func deleteTxRelationsAboveHeight(tx *sql.Tx, height uint32) error {
+	hashes, err := journaledHashes(tx, "pn_journal_tx_relation", height)
+	if err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(`DELETE FROM pn_transaction_relation WHERE entry_hash = ?`, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteHoldingAboveHeight(tx *sql.Tx, height uint32) error {
+	hashes, err := journaledHashes(tx, "pn_journal_holding", height)
+	if err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(`DELETE FROM pn_transaction_batch_holding WHERE entry_hash = ?`, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func journaledHashes(tx *sql.Tx, journalTable string, height uint32) ([][]byte, error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT entry_hash FROM %s WHERE height > ?`, journalTable), height)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes [][]byte
+	for rows.Next() {
+		var h []byte
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+func purgeJournalAboveHeight(tx *sql.Tx, height uint32) error {
+	for _, table := range []string{
+		"pn_journal_balance",
+		"pn_journal_grade_block",
+		"pn_journal_rate",
+		"pn_journal_tx_relation",
+		"pn_journal_holding",
+		"pn_journal_burn",
+		"pn_dblock_keymr",
+	} {
+		if err := deleteAboveHeight(tx, table, height); err != nil {
+			return err
+		}
+	}
+	return nil
+}
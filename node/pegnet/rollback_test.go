@@ -0,0 +1,113 @@
+package pegnet
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/pegnet/pegnetd/fat/fat2"
+)
+
+// coreSchema stands in for the pn_address/pn_balance/pn_grade_block tables
+// this package reads and writes but does not own (the real core schema is
+// migrated elsewhere). Together with the pn_rate and holding tables added by
+// snapshotCoreSchema, it's just enough for RollbackToHeight and
+// SummarizeRollback to exercise against real balance rows.
+const coreSchema = `
+CREATE TABLE IF NOT EXISTS pn_grade_block (
+	height INTEGER PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS pn_address (
+	id INTEGER PRIMARY KEY,
+	address TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS pn_balance (
+	address_id INTEGER NOT NULL,
+	ticker INTEGER NOT NULL,
+	balance INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (address_id, ticker)
+);
+`
+
+// openRollbackTestDB opens a migrated in-memory database with both this
+// package's journal tables and the core balance tables RollbackToHeight
+// reverses.
+func openRollbackTestDB(t *testing.T) (*Pegnet, *sql.Tx) {
+	t.Helper()
+	db := openTestDB(t)
+
+	p, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := db.Exec(snapshotCoreSchema); err != nil {
+		t.Fatalf("creating core schema: %v", err)
+	}
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return p, tx
+}
+
+// TestSummarizeAndRollbackToHeight drives the exact sequence SyncBlock
+// journals while crediting/debiting a balance across two heights, then
+// confirms SummarizeRollback previews the same net delta RollbackToHeight
+// actually applies, and that rolling back to the first height restores the
+// pre-second-height balance and purges the journal above it.
+func TestSummarizeAndRollbackToHeight(t *testing.T) {
+	p, tx := openRollbackTestDB(t)
+
+	const addressID = 1
+	if _, err := tx.Exec(`INSERT INTO pn_address (id, address) VALUES (?, ?)`, addressID, "FA1test"); err != nil {
+		t.Fatalf("insert address: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO pn_balance (address_id, ticker, balance) VALUES (?, ?, ?)`,
+		addressID, fat2.PTickerPEG, 150); err != nil {
+		t.Fatalf("insert balance: %v", err)
+	}
+
+	const firstHeight = 10
+	const secondHeight = 20
+
+	if err := p.JournalBalanceDelta(tx, firstHeight, addressID, fat2.PTickerPEG, 100); err != nil {
+		t.Fatalf("JournalBalanceDelta(first): %v", err)
+	}
+	if err := p.JournalBalanceDelta(tx, secondHeight, addressID, fat2.PTickerPEG, 50); err != nil {
+		t.Fatalf("JournalBalanceDelta(second): %v", err)
+	}
+
+	deltas, err := p.SummarizeRollback(tx, firstHeight)
+	if err != nil {
+		t.Fatalf("SummarizeRollback: %v", err)
+	}
+	if len(deltas) != 1 {
+		t.Fatalf("got %d deltas, want 1", len(deltas))
+	}
+	if deltas[0].AddressID != addressID || deltas[0].Ticker != fat2.PTickerPEG || deltas[0].Delta != -50 {
+		t.Fatalf("got delta %+v, want {AddressID:%d Ticker:%d Delta:-50}", deltas[0], addressID, fat2.PTickerPEG)
+	}
+
+	if err := p.RollbackToHeight(tx, firstHeight); err != nil {
+		t.Fatalf("RollbackToHeight: %v", err)
+	}
+
+	var balance int64
+	if err := tx.QueryRow(`SELECT balance FROM pn_balance WHERE address_id = ? AND ticker = ?`,
+		addressID, fat2.PTickerPEG).Scan(&balance); err != nil {
+		t.Fatalf("querying balance: %v", err)
+	}
+	if balance != 100 {
+		t.Fatalf("got balance %d after rollback, want 100", balance)
+	}
+
+	var remaining int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pn_journal_balance WHERE height > ?`, firstHeight).Scan(&remaining); err != nil {
+		t.Fatalf("counting remaining journal rows: %v", err)
+	}
+	if remaining != 0 {
+		t.Fatalf("got %d journal rows above %d after rollback, want 0", remaining, firstHeight)
+	}
+}
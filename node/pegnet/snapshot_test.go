@@ -0,0 +1,97 @@
+package pegnet
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// snapshotCoreSchema adds the remaining core tables SelectSnapshot and
+// ApplySnapshot touch beyond pn_address/pn_balance (see coreSchema in
+// rollback_test.go), standing in for the real core schema migrated
+// elsewhere.
+const snapshotCoreSchema = coreSchema + `
+CREATE TABLE IF NOT EXISTS pn_rate (
+	height INTEGER PRIMARY KEY,
+	entry_hash BLOB NOT NULL,
+	peg_usd INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS pn_transaction_batch_holding (
+	entry_hash BLOB PRIMARY KEY,
+	height INTEGER NOT NULL
+);
+`
+
+// TestSnapshotRoundTripPreservesBinaryColumns guards against the BLOB
+// columns scanRowsToMaps reads (e.g. pn_rate.entry_hash) getting corrupted by
+// a Snapshot's trip through JSON: encoding/json coerces Go strings to valid
+// UTF-8 on marshal, silently replacing invalid bytes with U+FFFD, so storing
+// a raw string(b) here would quietly mangle any hash whose bytes aren't
+// already valid UTF-8.
+func TestSnapshotRoundTripPreservesBinaryColumns(t *testing.T) {
+	db := openTestDB(t)
+	p, err := New(db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := db.Exec(snapshotCoreSchema); err != nil {
+		t.Fatalf("creating core schema: %v", err)
+	}
+
+	// A hash whose bytes are not valid UTF-8, so a naive string(b) conversion
+	// would be lossy.
+	entryHash := []byte{0xff, 0xfe, 0x00, 0xAB, 0xCD, 0x01}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO pn_rate (height, entry_hash, peg_usd) VALUES (?, ?, ?)`,
+		10, entryHash, 12345); err != nil {
+		t.Fatalf("inserting rate: %v", err)
+	}
+
+	snap, err := p.SelectSnapshot(tx, 10)
+	if err != nil {
+		t.Fatalf("SelectSnapshot: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	// Simulate writeSnapshot and `pegnetd snapshot import`: marshal to JSON
+	// and back, exactly as the snapshot file is written and later read.
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var roundTripped Snapshot
+	if err := json.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	db2 := openTestDB(t)
+	p2, err := New(db2)
+	if err != nil {
+		t.Fatalf("New (second db): %v", err)
+	}
+	if _, err := db2.Exec(snapshotCoreSchema); err != nil {
+		t.Fatalf("creating core schema on second db: %v", err)
+	}
+
+	tx2, err := db2.Begin()
+	if err != nil {
+		t.Fatalf("Begin (second db): %v", err)
+	}
+	defer tx2.Rollback()
+	if err := p2.ApplySnapshot(tx2, &roundTripped); err != nil {
+		t.Fatalf("ApplySnapshot: %v", err)
+	}
+
+	var gotHash []byte
+	if err := tx2.QueryRow(`SELECT entry_hash FROM pn_rate WHERE height = ?`, 10).Scan(&gotHash); err != nil {
+		t.Fatalf("querying restored entry_hash: %v", err)
+	}
+	if string(gotHash) != string(entryHash) {
+		t.Fatalf("got entry_hash %x after round trip, want %x", gotHash, entryHash)
+	}
+}
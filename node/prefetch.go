@@ -0,0 +1,216 @@
+package node
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPrefetchDepth and DefaultPrefetchWorkers are used when the
+// corresponding config keys are unset or zero.
+const (
+	DefaultPrefetchDepth   = 8
+	DefaultPrefetchWorkers = 4
+)
+
+// PrefetchedBlock holds everything SyncBlock needs to apply a height, fully
+// hydrated from factomd ahead of time so the apply loop only ever touches the
+// network to look up the next few heights, never to block on one.
+type PrefetchedBlock struct {
+	Height uint32
+
+	DBlock             *factom.DBlock
+	OPREBlock          *factom.EBlock
+	TransactionsEBlock *factom.EBlock
+	FBlock             *factom.FBlock
+
+	Err error // non-nil if hydrating this height failed
+}
+
+// Prefetcher runs a pool of workers that fetch and hydrate DBlocks ahead of
+// d.Sync.Synced, so the apply loop in DBlockSync never waits on network I/O.
+// It owns no database state and makes no mutations; it only reads from
+// factomd and hands fully-hydrated blocks to its output channel in height
+// order.
+type Prefetcher struct {
+	d       *Pegnetd
+	rpc     *FactomRPC
+	depth   int
+	workers int
+
+	out chan *PrefetchedBlock
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// fetchFn hydrates a single height. It defaults to p.fetch; tests
+	// override it to simulate factomd latency without a network round trip,
+	// while exercising the same worker pool and sequencing code Run uses.
+	fetchFn func(ctx context.Context, height uint32) *PrefetchedBlock
+}
+
+// NewPrefetcher creates a Prefetcher. Call Run to start the worker pool
+// fetching from fromHeight+1, and Stop to cancel it.
+func NewPrefetcher(d *Pegnetd, depth, workers int) *Prefetcher {
+	if depth <= 0 {
+		depth = DefaultPrefetchDepth
+	}
+	if workers <= 0 {
+		workers = DefaultPrefetchWorkers
+	}
+	p := &Prefetcher{
+		d:       d,
+		rpc:     NewFactomRPC(d.FactomClient, d.Config),
+		depth:   depth,
+		workers: workers,
+		out:     make(chan *PrefetchedBlock, depth),
+	}
+	p.fetchFn = p.fetch
+	return p
+}
+
+// Out returns the channel PrefetchedBlocks are delivered on, in ascending
+// height order starting at fromHeight+1.
+func (p *Prefetcher) Out() <-chan *PrefetchedBlock {
+	return p.out
+}
+
+// Run starts fetching heights fromHeight+1 .. upTo (inclusive) using the
+// configured number of worker goroutines, and closes Out() once they are all
+// delivered or the Prefetcher is stopped. It is safe to call Stop before Run
+// finishes; in-flight fetches are abandoned and nothing further is sent.
+func (p *Prefetcher) Run(ctx context.Context, fromHeight, upTo uint32) {
+	ctx, p.cancel = context.WithCancel(ctx)
+
+	heights := make(chan uint32)
+	results := make(chan *PrefetchedBlock)
+
+	p.wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for height := range heights {
+				result := p.fetchFn(ctx, height)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(heights)
+		for h := fromHeight + 1; h <= upTo; h++ {
+			select {
+			case heights <- h:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		p.wg.Wait()
+		close(results)
+	}()
+
+	// Results can arrive out of order since workers race each other; buffer
+	// and release them to Out() strictly in height order so the apply loop
+	// never has to reorder or look ahead itself.
+	go p.sequence(ctx, fromHeight, results)
+}
+
+// Stop cancels any in-flight or pending fetches and waits for the worker pool
+// to exit.
+func (p *Prefetcher) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+}
+
+func (p *Prefetcher) sequence(ctx context.Context, fromHeight uint32, results <-chan *PrefetchedBlock) {
+	defer close(p.out)
+
+	pending := make(map[uint32]*PrefetchedBlock)
+	next := fromHeight + 1
+
+	for block := range results {
+		pending[block.Height] = block
+		for ready, ok := pending[next]; ok; ready, ok = pending[next] {
+			delete(pending, next)
+			select {
+			case p.out <- ready:
+			case <-ctx.Done():
+				return
+			}
+			next++
+			if ready.Err != nil {
+				// Don't keep delivering blocks past a failure; the apply loop
+				// will surface the error and the next OuterSyncLoop iteration
+				// will start a fresh Prefetcher.
+				return
+			}
+		}
+	}
+}
+
+// fetch hydrates every piece of a single height that SyncBlock needs: the
+// DBlock, both chain-specific EBlocks and their entries, and the FBlock with
+// its transactions.
+func (p *Prefetcher) fetch(ctx context.Context, height uint32) *PrefetchedBlock {
+	rpc := p.rpc
+	if rpc == nil {
+		rpc = NewFactomRPC(p.d.FactomClient, p.d.Config)
+	}
+	block := &PrefetchedBlock{Height: height}
+
+	dblock := new(factom.DBlock)
+	dblock.Height = height
+	if err := rpc.GetDBlock(ctx, dblock); err != nil {
+		block.Err = err
+		return block
+	}
+	block.DBlock = dblock
+
+	if oprEBlock := dblock.EBlock(OPRChain); oprEBlock != nil {
+		if err := multiFetch(ctx, oprEBlock, rpc); err != nil {
+			block.Err = err
+			return block
+		}
+		block.OPREBlock = oprEBlock
+	}
+
+	if txEBlock := dblock.EBlock(TransactionChain); txEBlock != nil {
+		if err := multiFetch(ctx, txEBlock, rpc); err != nil {
+			block.Err = err
+			return block
+		}
+		block.TransactionsEBlock = txEBlock
+	}
+
+	fblock := new(factom.FBlock)
+	fblock.Header.Height = dblock.Height
+	if err := rpc.GetFBlock(ctx, fblock); err != nil {
+		block.Err = err
+		return block
+	}
+	for i := range fblock.Transactions {
+		if isDone(ctx) {
+			block.Err = context.Canceled
+			return block
+		}
+		if err := rpc.GetFactoidTransaction(ctx, &fblock.Transactions[i]); err != nil {
+			block.Err = err
+			return block
+		}
+	}
+	block.FBlock = fblock
+
+	log.WithFields(log.Fields{"height": height}).Tracef("prefetched block")
+	return block
+}
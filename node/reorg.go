@@ -0,0 +1,134 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/pegnet/pegnetd/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultReorgLookback is how many already-synced heights we double check
+// against factomd on every sync tick when config.ReorgLookback is unset.
+const DefaultReorgLookback = 10
+
+// checkForReorg compares the KeyMR factomd currently serves for our most
+// recently synced heights against the KeyMR we recorded when we synced them.
+// If they diverge, factomd has reorganized onto a different fork and our
+// synced state needs to be rewound.
+//
+// It returns the highest height whose KeyMR still matches, i.e. the height
+// DBlockSync should resume syncing from (height+1). If no reorg is detected,
+// it returns d.Sync.Synced unchanged.
+func (d *Pegnetd) checkForReorg(ctx context.Context) (uint32, error) {
+	if d.Sync.Synced == 0 {
+		return 0, nil
+	}
+
+	lookback := uint32(d.Config.GetInt(config.ReorgLookback))
+	if lookback == 0 {
+		lookback = DefaultReorgLookback
+	}
+	if lookback > d.Sync.Synced {
+		lookback = d.Sync.Synced
+	}
+
+	// Check the lookback window first. If even the oldest height in it still
+	// matches, we can stop without walking further back.
+	oldest := d.Sync.Synced - lookback
+	var divergedAt uint32
+	for height := d.Sync.Synced; height > oldest; height-- {
+		if isDone(ctx) {
+			return 0, context.Canceled
+		}
+
+		match, err := d.keyMRMatches(ctx, height)
+		if err != nil {
+			return 0, err
+		}
+		if !match {
+			divergedAt = height
+			break
+		}
+	}
+	if divergedAt == 0 {
+		return d.Sync.Synced, nil
+	}
+
+	// Found a divergence inside the lookback window: walk further back from
+	// just below it until we find the common ancestor.
+	height := divergedAt - 1
+	for height > 0 {
+		if isDone(ctx) {
+			return 0, context.Canceled
+		}
+
+		match, err := d.keyMRMatches(ctx, height)
+		if err != nil {
+			return 0, err
+		}
+		if match {
+			break
+		}
+		height--
+	}
+
+	log.WithFields(log.Fields{
+		"synced":   d.Sync.Synced,
+		"ancestor": height,
+	}).Warnf("chain reorg detected, rolling back to common ancestor")
+
+	return height, nil
+}
+
+// keyMRMatches reports whether the DBlock KeyMR factomd currently serves for
+// height matches the KeyMR we recorded when we originally synced it.
+func (d *Pegnetd) keyMRMatches(ctx context.Context, height uint32) (bool, error) {
+	stored, err := d.Pegnet.SelectDBlockKeyMR(height)
+	if err != nil {
+		return false, err
+	}
+	if stored == nil {
+		// We have no record of this height (e.g. it predates this feature, or
+		// is genesis). Treat it as matching so the lookback doesn't walk back
+		// past what we've ever recorded.
+		return true, nil
+	}
+
+	dblock := new(factom.DBlock)
+	dblock.Height = height
+	if err := NewFactomRPC(d.FactomClient, d.Config).GetDBlock(ctx, dblock); err != nil {
+		return false, fmt.Errorf("fetching dblock at height %d: %w", height, err)
+	}
+
+	return dblock.KeyMR == *stored, nil
+}
+
+// RollbackToHeight reverses all synced state above height using the
+// per-height journal written during SyncBlock, then resets d.Sync.Synced so
+// DBlockSync resumes at height+1.
+func (d *Pegnetd) RollbackToHeight(ctx context.Context, height uint32) error {
+	tx, err := d.Pegnet.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Pegnet.RollbackToHeight(tx, height); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	synced := d.Sync
+	synced.Synced = height
+	if err := d.Pegnet.InsertSynced(tx, synced); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.Sync = synced
+	return nil
+}
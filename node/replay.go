@@ -0,0 +1,70 @@
+package node
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Factom-Asset-Tokens/factom"
+)
+
+// ReplayHeight loads the debug artifacts dumped for height by an
+// ArtifactWriter (config.DebugArtifactDir) from dir and re-applies them
+// through the same grading/apply pipeline SyncBlock uses, against tx. This
+// lets a height be reproduced against an empty DB to chase down a bug without
+// talking to factomd at all.
+//
+// Only the pieces SyncBlock needs are reconstructed (the DBlock header, and
+// the OPR/transaction EBlock entries); it does not attempt to replay the
+// FBlock, since FCT burns are comparatively rare and easy to reproduce by
+// hand when needed. applyFactoidBlock treats a nil FBlock as "no burns this
+// height" rather than requiring one.
+func ReplayHeight(ctx context.Context, d *Pegnetd, tx *sql.Tx, dir string, height uint32) error {
+	heightDir := filepath.Join(dir, fmt.Sprintf("%d", height))
+
+	dblock := new(factom.DBlock)
+	if err := readArtifact(heightDir, "dblock", dblock); err != nil {
+		return fmt.Errorf("loading dblock artifact: %w", err)
+	}
+
+	block := &PrefetchedBlock{Height: height, DBlock: dblock}
+
+	var oprEntries []factom.Entry
+	if err := readArtifactIfExists(heightDir, "opr-entries", &oprEntries); err != nil {
+		return fmt.Errorf("loading opr entries artifact: %w", err)
+	}
+	if len(oprEntries) > 0 {
+		block.OPREBlock = &factom.EBlock{Entries: oprEntries}
+		block.OPREBlock.Height = height
+	}
+
+	var txEntries []factom.Entry
+	if err := readArtifactIfExists(heightDir, "transaction-entries", &txEntries); err != nil {
+		return fmt.Errorf("loading transaction entries artifact: %w", err)
+	}
+	if len(txEntries) > 0 {
+		block.TransactionsEBlock = &factom.EBlock{Entries: txEntries}
+		block.TransactionsEBlock.Height = height
+	}
+
+	return d.applyPrefetchedBlock(ctx, tx, block)
+}
+
+func readArtifact(dir, name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func readArtifactIfExists(dir, name string, v interface{}) error {
+	err := readArtifact(dir, name, v)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
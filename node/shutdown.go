@@ -0,0 +1,50 @@
+package node
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchShutdownSignals listens for os.Interrupt and escalates across up to
+// three deliveries, as seen in other coordinator nodes:
+//
+//  1. First SIGINT: request a graceful drain. The returned channel is
+//     closed so DBlockSync finishes and commits whatever height is
+//     currently in flight, then exits without starting another.
+//  2. Second SIGINT: abort. cancel is called, so DBlockSync rolls back
+//     its in-flight height and exits immediately.
+//  3. Third SIGINT: give up waiting on graceful shutdown entirely and
+//     call os.Exit(1).
+//
+// It returns the drain channel and logs a structured line at each stage so
+// operators can see exactly which stage a shutdown was interrupted at.
+// DBlockSync calls this itself, so callers don't need to wire it up.
+func WatchShutdownSignals(cancel context.CancelFunc) <-chan struct{} {
+	drain := make(chan struct{})
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+
+	go func() {
+		stage := 0
+		for range sigCh {
+			stage++
+			switch stage {
+			case 1:
+				log.WithFields(log.Fields{"stage": stage}).Warnf("shutdown requested: draining, will finish and commit the in-flight height then exit")
+				close(drain)
+			case 2:
+				log.WithFields(log.Fields{"stage": stage}).Warnf("shutdown requested again: aborting, rolling back the in-flight height and exiting immediately")
+				cancel()
+			default:
+				log.WithFields(log.Fields{"stage": stage}).Errorf("shutdown requested a third time: forcing immediate exit")
+				os.Exit(1)
+			}
+		}
+	}()
+
+	return drain
+}
@@ -16,19 +16,45 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// HeartbeatStaleAfter is how long since the last heartbeat before a tool like
+// `pegnetd rollback` should treat DBlockSync as no longer actively running
+// against a database. It is a few multiples of the expected per-height sync
+// time so a slow-but-alive sync isn't mistaken for a dead one.
+const HeartbeatStaleAfter = 60 * time.Second
+
 func (d *Pegnetd) GetCurrentSync() uint32 {
 	// Should be thread safe since we only have 1 routine writing to it
 	return d.Sync.Synced
 }
 
-// DBlockSync iterates through dblocks and syncs the various chains
+// DBlockSync iterates through dblocks and syncs the various chains.
+//
+// It escalates os.Interrupt the same way across up to three deliveries: the
+// first requests a graceful drain, so DBlockSync finishes and commits
+// whatever height is currently in flight before returning instead of
+// starting another; the second cancels ctx, so DBlockSync rolls back its
+// in-flight height and returns immediately; the third gives up waiting on a
+// graceful shutdown and calls os.Exit(1). See WatchShutdownSignals for the
+// implementation.
 func (d *Pegnetd) DBlockSync(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	drain := WatchShutdownSignals(cancel)
+
+	if err := d.Pegnet.EnsureSchema(); err != nil {
+		log.WithError(err).Fatal("failed to migrate journal schema")
+	}
+
 	retryPeriod := d.Config.GetDuration(config.DBlockSyncRetryPeriod)
 OuterSyncLoop:
 	for {
 		if isDone(ctx) {
 			return // If the user does ctl+c or something
 		}
+		if isClosed(drain) {
+			log.Infof("graceful shutdown requested, nothing in flight, exiting")
+			return
+		}
 
 		// Fetch the current highest height
 		heights := new(factom.Heights)
@@ -39,6 +65,17 @@ OuterSyncLoop:
 			continue // Loop will just keep retrying until factomd is reached
 		}
 
+		if ancestor, err := d.checkForReorg(ctx); err != nil {
+			log.WithError(err).Errorf("failed to check for reorg")
+			time.Sleep(retryPeriod)
+			continue
+		} else if ancestor < d.Sync.Synced {
+			if err := d.RollbackToHeight(ctx, ancestor); err != nil {
+				log.WithError(err).WithFields(log.Fields{"height": ancestor}).Fatal("failed to roll back after reorg")
+			}
+			continue
+		}
+
 		if d.Sync.Synced >= heights.DirectoryBlock {
 			// We are currently synced, nothing to do. If we are above it, the factomd could
 			// be rebooted
@@ -49,24 +86,53 @@ OuterSyncLoop:
 		var totalDur time.Duration
 		var iterations int
 
+		// The apply loop below only ever owns the DB transaction and the
+		// strict ordering of heights; all network I/O for heights ahead of
+		// d.Sync.Synced happens concurrently in the Prefetcher.
+		prefetcher := NewPrefetcher(d, d.Config.GetInt(config.PrefetchDepth), d.Config.GetInt(config.PrefetchWorkers))
+		prefetcher.Run(ctx, d.Sync.Synced, heights.DirectoryBlock)
+
 		begin := time.Now()
 		for d.Sync.Synced < heights.DirectoryBlock {
 			start := time.Now()
 			hLog := log.WithFields(log.Fields{"height": d.Sync.Synced + 1})
 			if isDone(ctx) {
+				prefetcher.Stop()
 				return
 			}
 
+			var block *PrefetchedBlock
+			select {
+			case b, ok := <-prefetcher.Out():
+				if !ok {
+					hLog.Errorf("prefetcher exited before reaching this height")
+					prefetcher.Stop()
+					time.Sleep(retryPeriod)
+					continue OuterSyncLoop
+				}
+				block = b
+			case <-ctx.Done():
+				prefetcher.Stop()
+				return
+			}
+
+			if block.Height != d.Sync.Synced+1 || block.Err != nil {
+				hLog.WithError(block.Err).Errorf("failed to prefetch height")
+				prefetcher.Stop()
+				time.Sleep(retryPeriod)
+				continue OuterSyncLoop
+			}
+
 			// start transaction for all block actions
 			tx, err := d.Pegnet.DB.BeginTx(ctx, nil)
 			if err != nil {
 				hLog.WithError(err).Errorf("failed to start transaction")
 				continue
 			}
-			// We are not synced, so we need to iterate through the dblocks and sync them
-			// one by one. We can only sync our current synced height +1
+			// We are not synced, so we need to apply the prefetched heights one by
+			// one. We can only apply our current synced height +1
 			// TODO: This skips the genesis block. I'm sure that is fine
-			if err := d.SyncBlock(ctx, tx, d.Sync.Synced+1); err != nil {
+			if err := d.applyPrefetchedBlock(ctx, tx, block); err != nil {
 				hLog.WithError(err).Errorf("failed to sync height")
 				time.Sleep(retryPeriod)
 				// If we fail, we backout to the outer loop. This allows error handling on factomd state to be a bit
@@ -76,6 +142,7 @@ OuterSyncLoop:
 					// TODO evaluate if we can recover from this point or not
 					hLog.WithError(err).Fatal("unable to roll back transaction")
 				}
+				prefetcher.Stop()
 				continue OuterSyncLoop
 			}
 
@@ -94,6 +161,13 @@ OuterSyncLoop:
 				continue OuterSyncLoop
 			}
 
+			// Keep the heartbeat fresh in the same transaction so a concurrently
+			// invoked `pegnetd rollback` can tell DBlockSync is still actively
+			// working this database and refuse to run.
+			if err := d.Pegnet.TouchHeartbeat(tx); err != nil {
+				hLog.WithError(err).Errorf("unable to update sync heartbeat")
+			}
+
 			err = tx.Commit()
 			if err != nil {
 				d.Sync.Synced--
@@ -103,6 +177,11 @@ OuterSyncLoop:
 					// TODO evaluate if we can recover from this point or not
 					hLog.WithError(err).Fatal("unable to roll back transaction")
 				}
+			} else {
+				// Taken in its own read-only transaction right after commit, so it
+				// never holds up the apply loop's own transaction and always sees a
+				// database state consistent with d.Sync.Synced == height.
+				d.maybeSnapshot(ctx)
 			}
 
 			elapsed := time.Since(start)
@@ -121,7 +200,14 @@ OuterSyncLoop:
 					"elapsed":    time.Since(begin),
 				}).Infof("sync stats")
 			}
+
+			if isClosed(drain) {
+				hLog.Infof("graceful shutdown requested, flushed height %d, exiting", d.Sync.Synced)
+				prefetcher.Stop()
+				return
+			}
 		}
+		prefetcher.Stop()
 
 	}
 
@@ -131,29 +217,38 @@ OuterSyncLoop:
 // the whole sync should be rolled back and not applied. An error should then be returned.
 // The context should be respected if it is cancelled
 func (d *Pegnetd) SyncBlock(ctx context.Context, tx *sql.Tx, height uint32) error {
-	fLog := log.WithFields(log.Fields{"height": height})
 	if isDone(ctx) { // Just an example about how to handle it being cancelled
 		return context.Canceled
 	}
 
-	dblock := new(factom.DBlock)
-	dblock.Height = height
-	if err := dblock.Get(d.FactomClient); err != nil {
-		return err
+	block := (&Prefetcher{d: d}).fetch(ctx, height)
+	if block.Err != nil {
+		return block.Err
 	}
+	return d.applyPrefetchedBlock(ctx, tx, block)
+}
 
-	// First, gather all entries we need from factomd
-	oprEBlock := dblock.EBlock(OPRChain)
-	if oprEBlock != nil {
-		if err := multiFetch(oprEBlock, d.FactomClient); err != nil {
-			return err
-		}
-	}
-	transactionsEBlock := dblock.EBlock(TransactionChain)
-	if transactionsEBlock != nil {
-		if err := multiFetch(transactionsEBlock, d.FactomClient); err != nil {
-			return err
-		}
+// applyPrefetchedBlock runs the grading/apply pipeline against a block that
+// was already fully hydrated, either synchronously by SyncBlock or ahead of
+// time by a Prefetcher. It owns no network I/O itself; everything it touches
+// is already in memory on the PrefetchedBlock.
+func (d *Pegnetd) applyPrefetchedBlock(ctx context.Context, tx *sql.Tx, block *PrefetchedBlock) error {
+	height := block.Height
+	fLog := log.WithFields(log.Fields{"height": height})
+
+	dblock := block.DBlock
+	oprEBlock := block.OPREBlock
+	transactionsEBlock := block.TransactionsEBlock
+
+	artifacts := artifactWriter(d)
+	artifacts.WriteDBlock(height, dblock)
+	artifacts.WriteOPREntries(height, oprEBlock)
+	artifacts.WriteTransactionEntries(height, transactionsEBlock)
+
+	// Record the KeyMR we synced this height under so future sync ticks can
+	// detect a reorg by noticing factomd now serves a different one.
+	if err := d.Pegnet.InsertDBlockKeyMR(tx, height, dblock.KeyMR); err != nil {
+		return err
 	}
 
 	// Then, grade the new OPR Block. The results of this will be used
@@ -166,12 +261,21 @@ func (d *Pegnetd) SyncBlock(ctx context.Context, tx *sql.Tx, height uint32) erro
 		if err != nil {
 			return err
 		}
+		if err := d.Pegnet.JournalGradeBlock(tx, height); err != nil {
+			return err
+		}
+		artifacts.WriteGradedBlock(height, gradedBlock)
 		winners := gradedBlock.Winners()
 		if 0 < len(winners) {
-			err = d.Pegnet.InsertRate(tx, height, winners[0].OPR.GetOrderedAssetsUint())
+			rates := winners[0].OPR.GetOrderedAssetsUint()
+			err = d.Pegnet.InsertRate(tx, height, rates)
 			if err != nil {
 				return err
 			}
+			if err := d.Pegnet.JournalRate(tx, height); err != nil {
+				return err
+			}
+			artifacts.WriteRates(height, rates)
 		} else {
 			fLog.WithFields(log.Fields{"section": "grading"}).Tracef("no winners")
 		}
@@ -196,7 +300,7 @@ func (d *Pegnetd) SyncBlock(ctx context.Context, tx *sql.Tx, height uint32) erro
 	// 3) Apply FCT --> pFCT burns that happened in this block
 	//    These funds will be available for transactions and conversions executed in the next block
 	// TODO: Check the order of operations on this and what block to add burns from.
-	if err := d.ApplyFactoidBlock(ctx, tx, dblock); err != nil {
+	if err := d.applyFactoidBlock(ctx, tx, dblock, block.FBlock); err != nil {
 		return err
 	}
 
@@ -210,8 +314,8 @@ func (d *Pegnetd) SyncBlock(ctx context.Context, tx *sql.Tx, height uint32) erro
 	return nil
 }
 
-func multiFetch(eblock *factom.EBlock, c *factom.Client) error {
-	err := eblock.Get(c)
+func multiFetch(ctx context.Context, eblock *factom.EBlock, rpc *FactomRPC) error {
+	err := rpc.GetEBlock(ctx, eblock)
 	if err != nil {
 		return err
 	}
@@ -224,7 +328,7 @@ func multiFetch(eblock *factom.EBlock, c *factom.Client) error {
 	for i := 0; i < 8; i++ {
 		go func() {
 			for j := range work {
-				errs <- eblock.Entries[j].Get(c)
+				errs <- rpc.GetEntry(ctx, &eblock.Entries[j])
 			}
 		}()
 	}
@@ -282,6 +386,11 @@ func (d *Pegnetd) ApplyTransactionBatchesInHolding(ctx context.Context, sqlTx *s
 			if err != nil && err != pegnet.InsufficientBalanceErr {
 				return nil
 			}
+			outcome, reason := "applied", ""
+			if err == pegnet.InsufficientBalanceErr {
+				outcome, reason = "rejected", err.Error()
+			}
+			artifactWriter(d).WriteTransactionBatch(currentHeight, txBatch.Hash, outcome, reason)
 		}
 	}
 	return nil
@@ -291,13 +400,16 @@ func (d *Pegnetd) ApplyTransactionBatchesInHolding(ctx context.Context, sqlTx *s
 // and applys the balance updates for all transaction batches able to be executed
 // immediately. If an error is returned, the sql.Tx should be rolled back by the caller.
 func (d *Pegnetd) ApplyTransactionBlock(sqlTx *sql.Tx, eblock *factom.EBlock) error {
+	artifacts := artifactWriter(d)
 	for _, entry := range eblock.Entries {
 		txBatch := fat2.NewTransactionBatch(entry)
 		err := txBatch.UnmarshalEntry()
 		if err != nil {
+			artifacts.WriteTransactionBatch(eblock.Height, entry.Hash, "rejected", "malformed entry")
 			continue // Bad formatted entry
 		}
 		if err := txBatch.Validate(); err != nil {
+			artifacts.WriteTransactionBatch(eblock.Height, txBatch.Hash, "rejected", err.Error())
 			continue
 		}
 		log.WithFields(log.Fields{
@@ -309,6 +421,7 @@ func (d *Pegnetd) ApplyTransactionBlock(sqlTx *sql.Tx, eblock *factom.EBlock) er
 		if err != nil {
 			return err
 		} else if isReplay {
+			artifacts.WriteTransactionBatch(eblock.Height, txBatch.Hash, "rejected", "replay")
 			continue
 		}
 		// At this point, we know that the transaction batch is valid and able to be executed.
@@ -321,13 +434,23 @@ func (d *Pegnetd) ApplyTransactionBlock(sqlTx *sql.Tx, eblock *factom.EBlock) er
 			if err != nil {
 				return err
 			}
+			if err := d.Pegnet.JournalHolding(sqlTx, eblock.Height, txBatch.Hash); err != nil {
+				return err
+			}
+			artifacts.WriteTransactionBatch(eblock.Height, txBatch.Hash, "held", "")
 			continue
 		}
 
 		// No conversions in the batch, it can be applied immediately
-		if err = d.applyTransactionBatch(sqlTx, txBatch, nil, eblock.Height); err != nil && err != pegnet.InsufficientBalanceErr {
+		err = d.applyTransactionBatch(sqlTx, txBatch, nil, eblock.Height)
+		if err != nil && err != pegnet.InsufficientBalanceErr {
 			return err
 		}
+		outcome, reason := "applied", ""
+		if err == pegnet.InsufficientBalanceErr {
+			outcome, reason = "rejected", err.Error()
+		}
+		artifacts.WriteTransactionBatch(eblock.Height, txBatch.Hash, outcome, reason)
 	}
 	return nil
 }
@@ -335,6 +458,7 @@ func (d *Pegnetd) ApplyTransactionBlock(sqlTx *sql.Tx, eblock *factom.EBlock) er
 // applyTransactionBatch
 //	currentHeight is just for tracing
 func (d *Pegnetd) applyTransactionBatch(sqlTx *sql.Tx, txBatch *fat2.TransactionBatch, rates map[fat2.PTicker]uint64, currentHeight uint32) error {
+	artifacts := artifactWriter(d)
 	for txIndex, tx := range txBatch.Transactions {
 		var inputAdrID int64
 		inputAdrID, txErr, err := d.Pegnet.SubFromBalance(sqlTx, &tx.Input.Address, tx.Input.Type, tx.Input.Amount)
@@ -347,6 +471,13 @@ func (d *Pegnetd) applyTransactionBatch(sqlTx *sql.Tx, txBatch *fat2.Transaction
 		if err != nil {
 			return err
 		}
+		if err := d.Pegnet.JournalBalanceDelta(sqlTx, currentHeight, inputAdrID, tx.Input.Type, -int64(tx.Input.Amount)); err != nil {
+			return err
+		}
+		if err := d.Pegnet.JournalTxRelation(sqlTx, currentHeight, txBatch.Hash); err != nil {
+			return err
+		}
+		artifacts.WriteBalanceDelta(currentHeight, &tx.Input.Address, tx.Input.Type, -int64(tx.Input.Amount))
 
 		if tx.IsConversion() {
 			if rates == nil || len(rates) == 0 {
@@ -359,10 +490,14 @@ func (d *Pegnetd) applyTransactionBatch(sqlTx *sql.Tx, txBatch *fat2.Transaction
 			if err != nil {
 				return err
 			}
-			_, err = d.Pegnet.AddToBalance(sqlTx, &tx.Input.Address, tx.Conversion, uint64(outputAmount))
+			outputAdrID, err := d.Pegnet.AddToBalance(sqlTx, &tx.Input.Address, tx.Conversion, uint64(outputAmount))
 			if err != nil {
 				return err
 			}
+			if err := d.Pegnet.JournalBalanceDelta(sqlTx, currentHeight, outputAdrID, tx.Conversion, outputAmount); err != nil {
+				return err
+			}
+			artifacts.WriteBalanceDelta(currentHeight, &tx.Input.Address, tx.Conversion, outputAmount)
 		} else {
 			for _, transfer := range tx.Transfers {
 				var outputAdrID int64
@@ -374,6 +509,13 @@ func (d *Pegnetd) applyTransactionBatch(sqlTx *sql.Tx, txBatch *fat2.Transaction
 				if err != nil {
 					return err
 				}
+				if err := d.Pegnet.JournalBalanceDelta(sqlTx, currentHeight, outputAdrID, tx.Input.Type, int64(transfer.Amount)); err != nil {
+					return err
+				}
+				if err := d.Pegnet.JournalTxRelation(sqlTx, currentHeight, txBatch.Hash); err != nil {
+					return err
+				}
+				artifacts.WriteBalanceDelta(currentHeight, &transfer.Address, tx.Input.Type, int64(transfer.Amount))
 			}
 		}
 	}
@@ -389,11 +531,33 @@ func (d *Pegnetd) applyTransactionBatch(sqlTx *sql.Tx, txBatch *fat2.Transaction
 // ApplyFactoidBlock applies the FCT burns that occurred within the given
 // DBlock. If an error is returned, the sql.Tx should be rolled back by the caller.
 func (d *Pegnetd) ApplyFactoidBlock(ctx context.Context, tx *sql.Tx, dblock *factom.DBlock) error {
+	rpc := NewFactomRPC(d.FactomClient, d.Config)
 	fblock := new(factom.FBlock)
 	fblock.Header.Height = dblock.Height
-	if err := fblock.Get(d.FactomClient); err != nil {
+	if err := rpc.GetFBlock(ctx, fblock); err != nil {
 		return err
 	}
+	for i := range fblock.Transactions {
+		if isDone(ctx) {
+			return context.Canceled
+		}
+		if err := rpc.GetFactoidTransaction(ctx, &fblock.Transactions[i]); err != nil {
+			return err
+		}
+	}
+	return d.applyFactoidBlock(ctx, tx, dblock, fblock)
+}
+
+// applyFactoidBlock is the network-free half of ApplyFactoidBlock: fblock
+// must already be hydrated, including every transaction's Get. This lets the
+// apply loop consume a Prefetcher-hydrated FBlock without re-fetching it.
+func (d *Pegnetd) applyFactoidBlock(ctx context.Context, tx *sql.Tx, dblock *factom.DBlock, fblock *factom.FBlock) error {
+	if fblock == nil {
+		// No FBlock to apply, e.g. ReplayHeight deliberately doesn't
+		// reconstruct one. Treat that as "no burns this height" rather than
+		// crashing.
+		return nil
+	}
 
 	var totalBurned uint64
 	var burns []factom.FactoidTransactionIO
@@ -407,10 +571,6 @@ func (d *Pegnetd) ApplyFactoidBlock(ctx context.Context, tx *sql.Tx, dblock *fac
 			return context.Canceled
 		}
 
-		if err := fblock.Transactions[i].Get(d.FactomClient); err != nil {
-			return err
-		}
-
 		tx := fblock.Transactions[i]
 		// Check number of inputs/outputs
 		if len(tx.ECOutputs) != 1 || len(tx.FCTInputs) != 1 || len(tx.FCTOutputs) > 0 {
@@ -438,13 +598,30 @@ func (d *Pegnetd) ApplyFactoidBlock(ctx context.Context, tx *sql.Tx, dblock *fac
 		log.WithFields(log.Fields{"height": dblock.Height, "amount": totalBurned, "quantity": len(burns)}).Debug("fct burned")
 	}
 
+	artifacts := artifactWriter(d)
+
 	// All burns are FCT inputs
 	for i := range burns {
 		var add factom.FAAddress
 		copy(add[:], burns[i].Address[:])
-		if _, err := d.Pegnet.AddToBalance(tx, &add, fat2.PTickerFCT, burns[i].Amount); err != nil {
+		addressID, err := d.Pegnet.AddToBalance(tx, &add, fat2.PTickerFCT, burns[i].Amount)
+		if err != nil {
+			return err
+		}
+		if err := d.Pegnet.JournalBalanceDelta(tx, dblock.Height, addressID, fat2.PTickerFCT, int64(burns[i].Amount)); err != nil {
 			return err
 		}
+		if err := d.Pegnet.JournalBurn(tx, dblock.Height, addressID, burns[i].Amount); err != nil {
+			return err
+		}
+		artifacts.WriteBalanceDelta(dblock.Height, &add, fat2.PTickerFCT, int64(burns[i].Amount))
+	}
+	if len(burns) > 0 {
+		burnArtifacts := make([]BurnArtifact, len(burns))
+		for i := range burns {
+			burnArtifacts[i] = BurnArtifact{Address: burns[i].Address.String(), Amount: burns[i].Amount}
+		}
+		artifacts.WriteBurns(dblock.Height, burnArtifacts)
 	}
 
 	return nil
@@ -453,6 +630,7 @@ func (d *Pegnetd) ApplyFactoidBlock(ctx context.Context, tx *sql.Tx, dblock *fac
 // ApplyGradedOPRBlock pays out PEG to the winners of the given GradedBlock.
 // If an error is returned, the sql.Tx should be rolled back by the caller.
 func (d *Pegnetd) ApplyGradedOPRBlock(tx *sql.Tx, gradedBlock grader.GradedBlock) error {
+	artifacts := artifactWriter(d)
 	winners := gradedBlock.Winners()
 	for i := range winners {
 		addr, err := factom.NewFAAddress(winners[i].OPR.GetAddress())
@@ -467,9 +645,14 @@ func (d *Pegnetd) ApplyGradedOPRBlock(tx *sql.Tx, gradedBlock grader.GradedBlock
 			continue
 		}
 
-		if _, err := d.Pegnet.AddToBalance(tx, &addr, fat2.PTickerPEG, uint64(winners[i].Payout())); err != nil {
+		addressID, err := d.Pegnet.AddToBalance(tx, &addr, fat2.PTickerPEG, uint64(winners[i].Payout()))
+		if err != nil {
 			return err
 		}
+		if err := d.Pegnet.JournalBalanceDelta(tx, uint32(winners[i].OPR.GetHeight()), addressID, fat2.PTickerPEG, winners[i].Payout()); err != nil {
+			return err
+		}
+		artifacts.WriteBalanceDelta(uint32(winners[i].OPR.GetHeight()), &addr, fat2.PTickerPEG, winners[i].Payout())
 	}
 	return nil
 }
@@ -482,3 +665,17 @@ func isDone(ctx context.Context) bool {
 		return false
 	}
 }
+
+// isClosed reports whether ch has been closed. A nil ch is treated as never
+// closed, so callers can pass an optional channel without a nil check.
+func isClosed(ch <-chan struct{}) bool {
+	if ch == nil {
+		return false
+	}
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
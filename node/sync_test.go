@@ -0,0 +1,21 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+)
+
+// TestApplyFactoidBlockNilFBlockIsNoop guards the ReplayHeight path, which
+// deliberately never reconstructs an FBlock: applyPrefetchedBlock still
+// unconditionally calls applyFactoidBlock, so a nil FBlock must be handled
+// as "nothing to apply" rather than panicking on fblock.Transactions.
+func TestApplyFactoidBlockNilFBlockIsNoop(t *testing.T) {
+	d := &Pegnetd{}
+	dblock := &factom.DBlock{}
+
+	if err := d.applyFactoidBlock(context.Background(), nil, dblock, nil); err != nil {
+		t.Fatalf("applyFactoidBlock with nil FBlock returned an error: %v", err)
+	}
+}